@@ -0,0 +1,41 @@
+package creds
+
+import (
+	"net/url"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// BoulderAuthInfo extends the stock credentials.TLSInfo with the specific
+// peer identity that serverTransportCredentials already verified during
+// ServerHandshake, so gRPC interceptors can authorize individual RPCs (e.g.
+// only the RA may call CA.IssuePrecertificate) without re-deriving the
+// identity from PeerCertificates or re-walking VerifiedChains themselves.
+type BoulderAuthInfo struct {
+	credentials.TLSInfo
+	// VerifiedCN is the subject CN of the verified chain's leaf certificate
+	// that matched the whitelist, if the peer was authorized by CN.
+	VerifiedCN string
+	// VerifiedSPIFFEID is the URI SAN of the verified chain's leaf
+	// certificate that matched the whitelist, if the peer was authorized by
+	// SPIFFE ID.
+	VerifiedSPIFFEID *url.URL
+	// MatchedWhitelistEntry is whichever of VerifiedCN or
+	// VerifiedSPIFFEID.String() authorized this peer.
+	MatchedWhitelistEntry string
+}
+
+// PeerIdentityFromContext returns the BoulderAuthInfo that
+// serverTransportCredentials.ServerHandshake attached to the RPC's peer, if
+// any. gRPC server interceptors can use this to authorize individual RPCs
+// based on the same verified-chain data the transport already validated.
+func PeerIdentityFromContext(ctx context.Context) (*BoulderAuthInfo, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(*BoulderAuthInfo)
+	return info, ok
+}
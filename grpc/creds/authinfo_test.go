@@ -0,0 +1,50 @@
+package creds
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// TestPeerIdentityFromContext proves that PeerIdentityFromContext round-trips
+// a *BoulderAuthInfo stashed in a peer.Peer by ServerHandshake, and that it
+// reports the `false` cases cleanly rather than panicking: no peer in the
+// context at all, and a peer whose AuthInfo isn't a *BoulderAuthInfo.
+func TestPeerIdentityFromContext(t *testing.T) {
+	t.Run("round-trips a BoulderAuthInfo", func(t *testing.T) {
+		want := &BoulderAuthInfo{VerifiedCN: "ra.boulder", MatchedWhitelistEntry: "ra.boulder"}
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: want})
+
+		got, ok := PeerIdentityFromContext(ctx)
+		if !ok {
+			t.Fatal("expected ok=true for a context carrying a BoulderAuthInfo peer")
+		}
+		if got != want {
+			t.Errorf("PeerIdentityFromContext() = %p, want %p (the exact BoulderAuthInfo stored)", got, want)
+		}
+	})
+
+	t.Run("no peer in context", func(t *testing.T) {
+		got, ok := PeerIdentityFromContext(context.Background())
+		if ok {
+			t.Fatal("expected ok=false when the context carries no peer")
+		}
+		if got != nil {
+			t.Errorf("expected a nil BoulderAuthInfo, got %+v", got)
+		}
+	})
+
+	t.Run("peer with a non-BoulderAuthInfo AuthInfo", func(t *testing.T) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{}})
+
+		got, ok := PeerIdentityFromContext(ctx)
+		if ok {
+			t.Fatal("expected ok=false for a peer whose AuthInfo isn't a *BoulderAuthInfo")
+		}
+		if got != nil {
+			t.Errorf("expected a nil BoulderAuthInfo, got %+v", got)
+		}
+	})
+}
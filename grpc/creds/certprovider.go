@@ -0,0 +1,53 @@
+package creds
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"golang.org/x/net/context"
+)
+
+// KeyMaterial bundles together the certificate chain a TransportCredentials
+// should present during a handshake and the pool of root CAs it should use
+// to verify its peer.
+type KeyMaterial struct {
+	Certificates []tls.Certificate
+	Roots        *x509.CertPool
+}
+
+// CertificateProvider supplies the KeyMaterial used for a TLS handshake.
+// clientTransportCredentials and serverTransportCredentials consult their
+// provider on every handshake rather than freezing `Certificates`/`RootCAs`
+// at construction time, so that an implementation which watches certificate
+// files on disk can rotate them into live use without requiring the process
+// to be restarted.
+type CertificateProvider interface {
+	// KeyMaterial returns the certificate chain and root pool to present and
+	// verify with for the handshake currently in progress.
+	KeyMaterial(ctx context.Context) (*KeyMaterial, error)
+	// Close releases any resources (e.g. a background file watcher) held by
+	// the provider. It is safe to call more than once.
+	Close() error
+}
+
+// staticCertificateProvider is a CertificateProvider that always returns the
+// same KeyMaterial. It lets `NewClientTransport` and `NewServerTransport`
+// keep accepting a plain `*tls.Config` while the handshake paths are
+// rewritten to consult a CertificateProvider uniformly.
+type staticCertificateProvider struct {
+	keyMaterial *KeyMaterial
+}
+
+// NewStaticCertificateProvider returns a CertificateProvider which always
+// serves the given KeyMaterial.
+func NewStaticCertificateProvider(km *KeyMaterial) CertificateProvider {
+	return &staticCertificateProvider{km}
+}
+
+func (p *staticCertificateProvider) KeyMaterial(ctx context.Context) (*KeyMaterial, error) {
+	return p.keyMaterial, nil
+}
+
+func (p *staticCertificateProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,35 @@
+package creds
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestStaticCertificateProvider proves that a staticCertificateProvider
+// always serves the KeyMaterial it was constructed with, and that Close is a
+// safe no-op.
+func TestStaticCertificateProvider(t *testing.T) {
+	cert, pool := generateTestCert(t)
+	want := &KeyMaterial{Certificates: []tls.Certificate{cert}, Roots: pool}
+
+	provider := NewStaticCertificateProvider(want)
+
+	for i := 0; i < 2; i++ {
+		got, err := provider.KeyMaterial(context.Background())
+		if err != nil {
+			t.Fatalf("KeyMaterial returned an error: %s", err)
+		}
+		if got != want {
+			t.Errorf("KeyMaterial() = %p, want %p (the exact KeyMaterial passed in)", got, want)
+		}
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Errorf("Close returned an error: %s", err)
+	}
+	if err := provider.Close(); err != nil {
+		t.Errorf("second Close returned an error: %s", err)
+	}
+}
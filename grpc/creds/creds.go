@@ -4,37 +4,109 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/credentials"
 )
 
+// defaultHandshakeTimeout bounds how long serverTransportCredentials will
+// wait for a client to complete a TLS handshake, if the caller doesn't
+// configure their own ServerAuthConfig.HandshakeTimeout. Without a bound, a
+// slow or malicious client that opens a connection and never proceeds with
+// the handshake would pin a goroutine and file descriptor indefinitely.
+const defaultHandshakeTimeout = 30 * time.Second
+
 // clientTransportCredentials is a grpc/credentials.TransportCredentials which supports
 // connecting to, and verifying multiple DNS names
 type clientTransportCredentials struct {
-	clientConfig *tls.Config
+	provider     CertificateProvider
+	versionRange TLSVersionRange
+	cipherSuites []uint16
+
+	negotiatedVersion atomic.Value // holds a string
+}
+
+// ClientAuthConfig configures a clientTransportCredentials.
+type ClientAuthConfig struct {
+	// ClientConfig supplies a static certificate chain and root pool, for
+	// callers that don't need a CertificateProvider. Ignored if Provider is
+	// set.
+	ClientConfig *tls.Config
+	// Provider supplies the certificate chain and root pool consulted on
+	// every handshake.
+	Provider CertificateProvider
+	// VersionRange bounds the TLS protocol versions negotiated with the
+	// server. A zero value uses defaultTLSVersionRange.
+	VersionRange TLSVersionRange
+	// CipherSuites, if non-empty, overrides the default cipher suite
+	// preference order negotiated with the server.
+	CipherSuites []uint16
 }
 
 // New returns a new initialized grpc/credentials.TransportCredentials
 func NewClientTransport(clientConfig *tls.Config) credentials.TransportCredentials {
-	return &clientTransportCredentials{clientConfig}
+	return NewClientTransportWithConfig(ClientAuthConfig{ClientConfig: clientConfig})
+}
+
+// NewClientTransportFromProvider returns a new initialized
+// grpc/credentials.TransportCredentials which consults `provider` for its
+// certificate chain and root pool on every handshake, instead of freezing
+// them at construction time (see CertificateProvider for why that matters).
+func NewClientTransportFromProvider(provider CertificateProvider) credentials.TransportCredentials {
+	return NewClientTransportWithConfig(ClientAuthConfig{Provider: provider})
+}
+
+// NewClientTransportWithConfig returns a new initialized
+// grpc/credentials.TransportCredentials as described by `cfg`. It is the
+// most general constructor: it's the only one that can configure a
+// TLSVersionRange or CipherSuites.
+//
+// If `cfg.Provider` is nil and `cfg.ClientConfig` is non-nil, a static
+// provider is built from `cfg.ClientConfig.Certificates`/`RootCAs`. A `cfg`
+// with both left nil is left for `ClientHandshake` to reject cleanly rather
+// than leaving `tc.provider` nil and panicking on every handshake.
+func NewClientTransportWithConfig(cfg ClientAuthConfig) credentials.TransportCredentials {
+	provider := cfg.Provider
+	if provider == nil && cfg.ClientConfig != nil {
+		provider = NewStaticCertificateProvider(&KeyMaterial{
+			Certificates: cfg.ClientConfig.Certificates,
+			Roots:        cfg.ClientConfig.RootCAs,
+		})
+	}
+	return &clientTransportCredentials{
+		provider:     provider,
+		versionRange: cfg.VersionRange,
+		cipherSuites: cfg.CipherSuites,
+	}
 }
 
 // ClientHandshake performs the TLS handshake for a client -> server connection
 func (tc *clientTransportCredentials) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if tc.provider == nil {
+		return nil, nil, fmt.Errorf("boulder/grpc/creds: `provider` must not be nil")
+	}
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, nil, err
 	}
-	// We need to set the `ServerName` attribute for the tls.Config. Since we
-	// can't modify the existing `tc.clientConfig` we create a new one and port over
-	// the few fields we were using the `clientConfig` as a container for.
+	km, err := tc.provider.KeyMaterial(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("boulder/grpc/creds: fetching KeyMaterial: %s", err)
+	}
+	versionRange := tc.versionRange.orDefault()
+	// We need to set the `ServerName` attribute for the tls.Config, along
+	// with the `Certificates`/`RootCAs` freshly fetched from `tc.provider`,
+	// so we build a new `tls.Config` for each handshake rather than mutating
+	// a shared one.
 	tlsConfig := &tls.Config{
-		MinVersion:   tls.VersionTLS12, // Override default of tls.VersionTLS10
-		MaxVersion:   tls.VersionTLS12, // Same as default in golang <= 1.6
+		MinVersion:   versionRange.MinVersion,
+		MaxVersion:   versionRange.MaxVersion,
 		ServerName:   host,
-		RootCAs:      tc.clientConfig.RootCAs,
-		Certificates: tc.clientConfig.Certificates,
+		RootCAs:      km.Roots,
+		Certificates: km.Certificates,
+		CipherSuites: tc.cipherSuites,
 	}
 	conn := tls.Client(rawConn, tlsConfig)
 	errChan := make(chan error, 1)
@@ -49,6 +121,7 @@ func (tc *clientTransportCredentials) ClientHandshake(ctx context.Context, addr
 			_ = rawConn.Close()
 			return nil, nil, fmt.Errorf("boulder/grpc/creds: TLS handshake failed: %s", err)
 		}
+		tc.negotiatedVersion.Store(securityVersionString(conn.ConnectionState().Version))
 		return conn, nil, nil
 	}
 }
@@ -60,11 +133,17 @@ func (tc *clientTransportCredentials) ServerHandshake(rawConn net.Conn) (net.Con
 			"clientTransportCredentials")
 }
 
-// Info returns information about the transport protocol used
+// Info returns information about the transport protocol used. Once a
+// handshake has completed, SecurityVersion reflects the TLS version that was
+// actually negotiated with the server rather than the configured maximum.
 func (tc *clientTransportCredentials) Info() credentials.ProtocolInfo {
+	version, ok := tc.negotiatedVersion.Load().(string)
+	if !ok {
+		version = securityVersionString(tc.versionRange.orDefault().MaxVersion)
+	}
 	return credentials.ProtocolInfo{
 		SecurityProtocol: "tls",
-		SecurityVersion:  "1.2", // We *only* support TLS 1.2
+		SecurityVersion:  version,
 	}
 }
 
@@ -82,56 +161,166 @@ func (tc *clientTransportCredentials) RequireTransportSecurity() bool {
 // filtering acceptable peers by client certificate SAN.
 type serverTransportCredentials struct {
 	serverConfig *tls.Config
-	whitelist    map[string]struct{}
+	provider     CertificateProvider
+	authConfig   ServerAuthConfig
+
+	negotiatedVersion atomic.Value // holds a string
 }
 
-func NewServerTransport(serverConfig *tls.Config, whitelist map[string]struct{}) credentials.TransportCredentials {
-	return &serverTransportCredentials{serverConfig, whitelist}
+// ServerAuthConfig describes which peers a serverTransportCredentials should
+// accept, on top of having a certificate chain that verifies against the
+// server's configured client CAs.
+type ServerAuthConfig struct {
+	// ServerConfig supplies the non-certificate TLS handshake parameters
+	// (e.g. ClientAuth) for the resulting transport credentials.
+	ServerConfig *tls.Config
+	// Provider supplies the certificate chain and client CA pool consulted
+	// on every handshake.
+	Provider CertificateProvider
+	// AcceptedCNs, if non-empty, whitelists peers whose verified leaf
+	// certificate has a subject CN present in the set.
+	AcceptedCNs map[string]struct{}
+	// AcceptedSPIFFEIDs, if non-empty, whitelists peers whose verified leaf
+	// certificate has a URI SAN present in the set, e.g.
+	// "spiffe://boulder/ra". A peer is accepted if it matches either
+	// AcceptedCNs or AcceptedSPIFFEIDs.
+	AcceptedSPIFFEIDs map[string]struct{}
+	// TrustDomain, if non-empty, is an additional check applied before a
+	// peer's URI SAN is compared against AcceptedSPIFFEIDs: the URI must
+	// have scheme "spiffe" and a host matching TrustDomain.
+	TrustDomain string
+	// HandshakeTimeout bounds how long ServerHandshake will wait for a
+	// client to complete the TLS handshake. A non-positive value uses
+	// defaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	// TLSVersionRange bounds the TLS protocol versions negotiated with a
+	// client. A zero value uses defaultTLSVersionRange.
+	TLSVersionRange TLSVersionRange
+	// CipherSuites, if non-empty, overrides the default cipher suite
+	// preference order negotiated with a client.
+	CipherSuites []uint16
+}
+
+func NewServerTransport(serverConfig *tls.Config, timeout time.Duration, whitelist map[string]struct{}) credentials.TransportCredentials {
+	var provider CertificateProvider
+	if serverConfig != nil {
+		provider = NewStaticCertificateProvider(&KeyMaterial{
+			Certificates: serverConfig.Certificates,
+			Roots:        serverConfig.ClientCAs,
+		})
+	}
+	return NewServerTransportFromProvider(serverConfig, provider, timeout, whitelist)
 }
 
-func (tc *serverTransportCredentials) peerIsWhitelisted(peerState tls.ConnectionState) error {
+// NewServerTransportFromProvider returns a new initialized
+// grpc/credentials.TransportCredentials which consults `provider` for its
+// certificate chain and client CA pool on every handshake, instead of
+// freezing them at construction time. `serverConfig` still supplies the
+// non-certificate handshake parameters (e.g. ClientAuth, CipherSuites).
+func NewServerTransportFromProvider(serverConfig *tls.Config, provider CertificateProvider, timeout time.Duration, whitelist map[string]struct{}) credentials.TransportCredentials {
+	return NewServerTransportWithConfig(ServerAuthConfig{
+		ServerConfig:     serverConfig,
+		Provider:         provider,
+		AcceptedCNs:      whitelist,
+		HandshakeTimeout: timeout,
+	})
+}
+
+// NewServerTransportWithConfig returns a new initialized
+// grpc/credentials.TransportCredentials authorizing peers as described by
+// `cfg`. It is the most general constructor: it's the only one that can
+// whitelist peers by SPIFFE URI SAN rather than subject CN.
+//
+// If `cfg.Provider` is nil and `cfg.ServerConfig` is non-nil, a static
+// provider is built from `cfg.ServerConfig.Certificates`/`ClientCAs`,
+// mirroring the fallback `NewClientTransportWithConfig` uses for
+// `cfg.ClientConfig` - this keeps a `ServerAuthConfig` built without a
+// Provider from leaving `tc.provider` nil and panicking on every incoming
+// connection. A `cfg.ServerConfig` of nil is left for `ServerHandshake` to
+// reject cleanly, the same way it already rejects a nil `serverConfig` from
+// the other constructors.
+func NewServerTransportWithConfig(cfg ServerAuthConfig) credentials.TransportCredentials {
+	provider := cfg.Provider
+	if provider == nil && cfg.ServerConfig != nil {
+		provider = NewStaticCertificateProvider(&KeyMaterial{
+			Certificates: cfg.ServerConfig.Certificates,
+			Roots:        cfg.ServerConfig.ClientCAs,
+		})
+	}
+	return &serverTransportCredentials{
+		serverConfig: cfg.ServerConfig,
+		provider:     provider,
+		authConfig:   cfg,
+	}
+}
+
+// peerIsWhitelisted checks `peerState`'s verified chains against `tc`'s
+// configured whitelist. If the peer is accepted, it returns a BoulderAuthInfo
+// describing which identity matched, for interceptors to consult later via
+// PeerIdentityFromContext.
+func (tc *serverTransportCredentials) peerIsWhitelisted(peerState tls.ConnectionState) (*BoulderAuthInfo, error) {
+	info := &BoulderAuthInfo{TLSInfo: credentials.TLSInfo{State: peerState}}
+
 	// If there's no whitelist, all clients are OK
-	if tc.whitelist == nil {
-		return nil
+	if len(tc.authConfig.AcceptedCNs) == 0 && len(tc.authConfig.AcceptedSPIFFEIDs) == 0 {
+		return info, nil
 	}
 
 	// Otherwise its time to start inspecting the peer's `VerifiedChains`
 	chains := peerState.VerifiedChains
 	if len(chains) < 1 {
-		return fmt.Errorf("boulder/grpc/creds: peer had zero VerifiedChains")
+		return nil, fmt.Errorf("boulder/grpc/creds: peer had zero VerifiedChains")
 	}
 
 	/*
 	 * For each of the peer's verified chains we can look at the chain's leaf
-	 * certificate and check whether the subject common name is in the whitelist.
-	 * At least one chain must have a leaf certificate with a subject CN that
-	 * matches the whitelist
+	 * certificate and check whether its subject CN or one of its URI SANs is
+	 * in the whitelist. At least one chain must have a leaf certificate that
+	 * matches.
 	 *
 	 * Its important we process `VerifiedChains` instead of processing
-	 * `PeerCertificates` to ensure that we match the subject CN of the
-	 * leaf certificate that was verified in `conn.Handshake()`. To do otherwise
+	 * `PeerCertificates` to ensure that we match against the leaf
+	 * certificate that was verified in `conn.Handshake()`. To do otherwise
 	 * would allow an attacker to include a whitelisted certificate in
 	 * `PeerCertificates` that matched the whitelist but wasn't used in the chain
 	 * the server validated.
 	 */
 	var whitelisted bool
 	for _, chain := range chains {
-		leafSubjectCN := chain[0].Subject.CommonName
-		if _, ok := tc.whitelist[leafSubjectCN]; ok {
+		leaf := chain[0]
+		if _, ok := tc.authConfig.AcceptedCNs[leaf.Subject.CommonName]; ok {
 			whitelisted = true
+			info.VerifiedCN = leaf.Subject.CommonName
+			info.MatchedWhitelistEntry = leaf.Subject.CommonName
+			break
+		}
+		for _, uri := range leaf.URIs {
+			if tc.authConfig.TrustDomain != "" &&
+				(uri.Scheme != "spiffe" || uri.Host != tc.authConfig.TrustDomain) {
+				continue
+			}
+			if _, ok := tc.authConfig.AcceptedSPIFFEIDs[uri.String()]; ok {
+				whitelisted = true
+				info.VerifiedSPIFFEID = uri
+				info.MatchedWhitelistEntry = uri.String()
+				break
+			}
+		}
+		if whitelisted {
+			break
 		}
 	}
 
 	// If none of the peer's validated chains had a leaf certificate with a
-	// whitelisted CN then we have to reject the connection
+	// whitelisted CN or SPIFFE ID then we have to reject the connection
 	if !whitelisted {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"boulder/grpc/creds: peer's verified TLS chains did not include a leaf " +
-				"certificate with a whitelisted subject CN")
+				"certificate with a whitelisted subject CN or SPIFFE ID")
 	}
 
 	// Otherwise, the peer is whitelisted! Come on in!
-	return nil
+	return info, nil
 }
 
 // ServerHandshake performs the TLS handshake for a server <- client connection
@@ -140,18 +329,57 @@ func (tc *serverTransportCredentials) ServerHandshake(rawConn net.Conn) (net.Con
 		return nil, nil, fmt.Errorf("boulder/grpc/creds: `serverConfig` must not be nil")
 	}
 
-	// Perform the server <- client TLS handshake
-	conn := tls.Server(rawConn, tc.serverConfig)
-	if err := conn.Handshake(); err != nil {
-		return nil, nil, err
+	km, err := tc.provider.KeyMaterial(context.Background())
+	if err != nil {
+		return nil, nil, fmt.Errorf("boulder/grpc/creds: fetching KeyMaterial: %s", err)
+	}
+	// We clone `tc.serverConfig` for each handshake so that the
+	// `Certificates`/`ClientCAs` freshly fetched from `tc.provider` are used,
+	// without disturbing the non-certificate settings (e.g. ClientAuth) the
+	// caller configured on the shared `serverConfig`.
+	tlsConfig := tc.serverConfig.Clone()
+	tlsConfig.Certificates = km.Certificates
+	tlsConfig.ClientCAs = km.Roots
+	versionRange := tc.authConfig.TLSVersionRange.orDefault()
+	tlsConfig.MinVersion = versionRange.MinVersion
+	tlsConfig.MaxVersion = versionRange.MaxVersion
+	if len(tc.authConfig.CipherSuites) > 0 {
+		tlsConfig.CipherSuites = tc.authConfig.CipherSuites
+	}
+
+	timeout := tc.authConfig.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+
+	// Perform the server <- client TLS handshake in a goroutine so that a
+	// client which never completes it (deliberately or otherwise) can't pin
+	// this goroutine, and the file descriptor it holds, indefinitely.
+	conn := tls.Server(rawConn, tlsConfig)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- conn.Handshake()
+	}()
+	select {
+	case <-time.After(timeout):
+		_ = rawConn.Close()
+		return nil, nil, fmt.Errorf(
+			"boulder/grpc/creds: server TLS handshake timed out after %s", timeout)
+	case err := <-errChan:
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// If the peer isn't whitelisted, abort and return an error
-	if err := tc.peerIsWhitelisted(conn.ConnectionState()); err != nil {
+	info, err := tc.peerIsWhitelisted(conn.ConnectionState())
+	if err != nil {
 		return nil, nil, err
 	}
 
-	return conn, credentials.TLSInfo{conn.ConnectionState()}, nil
+	tc.negotiatedVersion.Store(securityVersionString(conn.ConnectionState().Version))
+
+	return conn, info, nil
 }
 
 func (tc *serverTransportCredentials) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
@@ -160,10 +388,17 @@ func (tc *serverTransportCredentials) ClientHandshake(ctx context.Context, addr
 			"serverTransportCredentials")
 }
 
+// Info returns information about the transport protocol used. Once a
+// handshake has completed, SecurityVersion reflects the TLS version that was
+// actually negotiated with the client rather than the configured maximum.
 func (tc *serverTransportCredentials) Info() credentials.ProtocolInfo {
+	version, ok := tc.negotiatedVersion.Load().(string)
+	if !ok {
+		version = securityVersionString(tc.authConfig.TLSVersionRange.orDefault().MaxVersion)
+	}
 	return credentials.ProtocolInfo{
 		SecurityProtocol: "tls",
-		SecurityVersion:  "1.2", // We *only* support TLS 1.2
+		SecurityVersion:  version,
 	}
 }
 
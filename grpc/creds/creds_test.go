@@ -0,0 +1,71 @@
+package creds
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestServerHandshakeTimeout checks that a peer which opens the connection
+// but never proceeds with the TLS handshake can't pin ServerHandshake's
+// goroutine indefinitely: it should time out and close `rawConn`.
+func TestServerHandshakeTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	tc := NewServerTransport(&tls.Config{}, 50*time.Millisecond, nil)
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := tc.ServerHandshake(serverConn)
+		errChan <- err
+	}()
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("expected ServerHandshake to return an error when the peer never completes the handshake")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServerHandshake did not return within 1s of its configured 50ms HandshakeTimeout")
+	}
+
+	// `rawConn` (serverConn) should have been closed on timeout, so writes
+	// from the other end of the pipe should now fail.
+	if _, err := clientConn.Write([]byte("hello")); err == nil {
+		t.Fatal("expected write on clientConn to fail after ServerHandshake closed its peer")
+	}
+}
+
+// TestNewServerTransportWithConfigNilServerConfig proves that a
+// ServerAuthConfig built without a ServerConfig (and so without a Provider
+// either) doesn't panic at construction time, and that ServerHandshake
+// rejects it cleanly instead.
+func TestNewServerTransportWithConfigNilServerConfig(t *testing.T) {
+	tc := NewServerTransportWithConfig(ServerAuthConfig{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	if _, _, err := tc.ServerHandshake(serverConn); err == nil {
+		t.Fatal("expected ServerHandshake to return an error for a nil serverConfig")
+	}
+}
+
+// TestNewClientTransportWithConfigNilClientConfig proves that a
+// ClientAuthConfig built without a ClientConfig (and so without a Provider
+// either) doesn't panic at construction time, and that ClientHandshake
+// rejects it cleanly instead.
+func TestNewClientTransportWithConfigNilClientConfig(t *testing.T) {
+	tc := NewClientTransportWithConfig(ClientAuthConfig{})
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	if _, _, err := tc.ClientHandshake(context.Background(), "example.com:443", clientConn); err == nil {
+		t.Fatal("expected ClientHandshake to return an error for a nil provider")
+	}
+}
@@ -0,0 +1,152 @@
+package creds
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// writeTestCertFiles generates a self-signed certificate/key pair with the
+// given subject CN and writes out a cert file, key file, and root file (the
+// cert is its own root, since it's self-signed) under `dir`.
+func writeTestCertFiles(t *testing.T, dir, commonName string) (certFile, keyFile, rootFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	rootFile = filepath.Join(dir, "root.pem")
+	rewriteTestCertFiles(t, certFile, keyFile, rootFile, commonName)
+	return certFile, keyFile, rootFile
+}
+
+// rewriteTestCertFiles overwrites an existing cert/key/root bundle with a
+// freshly generated certificate carrying the given subject CN, simulating a
+// cert rotation landing on disk.
+func rewriteTestCertFiles(t *testing.T, certFile, keyFile, rootFile, commonName string) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating test cert: %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("writing cert file: %s", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("writing key file: %s", err)
+	}
+	if err := os.WriteFile(rootFile, certPEM, 0600); err != nil {
+		t.Fatalf("writing root file: %s", err)
+	}
+}
+
+// commonNameOf returns the subject CN of a provider's currently loaded leaf
+// certificate.
+func commonNameOf(t *testing.T, km *KeyMaterial) string {
+	t.Helper()
+	if len(km.Certificates) != 1 || len(km.Certificates[0].Certificate) == 0 {
+		t.Fatalf("KeyMaterial has no leaf certificate: %+v", km)
+	}
+	leaf, err := x509.ParseCertificate(km.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %s", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// TestFileCertificateProviderReloadsOnChange proves that rewriting the
+// on-disk cert/key/root bundle is picked up by a running
+// fileCertificateProvider within a few poll intervals, without requiring a
+// restart.
+func TestFileCertificateProviderReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, rootFile := writeTestCertFiles(t, dir, "v1.boulder")
+
+	provider, err := NewFileCertificateProvider(certFile, keyFile, rootFile, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewFileCertificateProvider: %s", err)
+	}
+	defer provider.Close()
+
+	km, err := provider.KeyMaterial(context.Background())
+	if err != nil {
+		t.Fatalf("KeyMaterial: %s", err)
+	}
+	if got := commonNameOf(t, km); got != "v1.boulder" {
+		t.Fatalf("initial KeyMaterial CN = %q, want %q", got, "v1.boulder")
+	}
+
+	rewriteTestCertFiles(t, certFile, keyFile, rootFile, "v2.boulder")
+
+	deadline := time.Now().Add(2 * time.Second)
+	var lastCN string
+	for time.Now().Before(deadline) {
+		km, err := provider.KeyMaterial(context.Background())
+		if err != nil {
+			t.Fatalf("KeyMaterial: %s", err)
+		}
+		lastCN = commonNameOf(t, km)
+		if lastCN == "v2.boulder" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("KeyMaterial never observed the rotated bundle; last saw CN %q", lastCN)
+}
+
+// TestFileCertificateProviderCloseStopsWatch proves that Close halts the
+// background poller, so a bundle rewritten afterwards is not picked up, and
+// that Close is safe to call more than once.
+func TestFileCertificateProviderCloseStopsWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, rootFile := writeTestCertFiles(t, dir, "v1.boulder")
+
+	provider, err := NewFileCertificateProvider(certFile, keyFile, rootFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewFileCertificateProvider: %s", err)
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	rewriteTestCertFiles(t, certFile, keyFile, rootFile, "v2.boulder")
+	time.Sleep(100 * time.Millisecond)
+
+	km, err := provider.KeyMaterial(context.Background())
+	if err != nil {
+		t.Fatalf("KeyMaterial: %s", err)
+	}
+	if got := commonNameOf(t, km); got != "v1.boulder" {
+		t.Fatalf("KeyMaterial CN = %q after Close, want unchanged %q", got, "v1.boulder")
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("second Close returned an error: %s", err)
+	}
+}
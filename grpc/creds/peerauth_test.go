@@ -0,0 +1,126 @@
+package creds
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"testing"
+)
+
+// leafOf parses the leaf certificate out of a tls.Certificate produced by
+// generateTestCertWithSANs, for use as a VerifiedChains entry.
+func leafOf(t *testing.T, cert tls.Certificate) *x509.Certificate {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %s", err)
+	}
+	return leaf
+}
+
+// TestPeerIsWhitelisted is the security-critical test for this package: it
+// proves AcceptedCNs and AcceptedSPIFFEIDs matching work, that TrustDomain
+// rejects an out-of-domain URI SAN, and that an unlisted peer is rejected.
+func TestPeerIsWhitelisted(t *testing.T) {
+	cnCert, _ := generateTestCertWithSANs(t, "ra.boulder", nil)
+	spiffeCert, _ := generateTestCertWithSANs(t, "unused-cn", []*url.URL{
+		{Scheme: "spiffe", Host: "boulder", Path: "/ra"},
+	})
+	otherCert, _ := generateTestCertWithSANs(t, "unknown", nil)
+
+	cnLeaf := leafOf(t, cnCert)
+	spiffeLeaf := leafOf(t, spiffeCert)
+	otherLeaf := leafOf(t, otherCert)
+
+	tests := []struct {
+		name        string
+		authConfig  ServerAuthConfig
+		leaf        *x509.Certificate
+		wantErr     bool
+		wantMatched string
+	}{
+		{
+			name:       "no whitelist accepts any peer",
+			authConfig: ServerAuthConfig{},
+			leaf:       otherLeaf,
+		},
+		{
+			name:        "accepted CN is whitelisted",
+			authConfig:  ServerAuthConfig{AcceptedCNs: map[string]struct{}{"ra.boulder": {}}},
+			leaf:        cnLeaf,
+			wantMatched: "ra.boulder",
+		},
+		{
+			name:       "unlisted CN is rejected",
+			authConfig: ServerAuthConfig{AcceptedCNs: map[string]struct{}{"ra.boulder": {}}},
+			leaf:       otherLeaf,
+			wantErr:    true,
+		},
+		{
+			name:        "accepted SPIFFE ID is whitelisted",
+			authConfig:  ServerAuthConfig{AcceptedSPIFFEIDs: map[string]struct{}{"spiffe://boulder/ra": {}}},
+			leaf:        spiffeLeaf,
+			wantMatched: "spiffe://boulder/ra",
+		},
+		{
+			name:        "accepted SPIFFE ID within TrustDomain is whitelisted",
+			authConfig:  ServerAuthConfig{AcceptedSPIFFEIDs: map[string]struct{}{"spiffe://boulder/ra": {}}, TrustDomain: "boulder"},
+			leaf:        spiffeLeaf,
+			wantMatched: "spiffe://boulder/ra",
+		},
+		{
+			name: "SPIFFE ID outside TrustDomain is rejected",
+			authConfig: ServerAuthConfig{
+				AcceptedSPIFFEIDs: map[string]struct{}{"spiffe://boulder/ra": {}},
+				TrustDomain:       "other-domain",
+			},
+			leaf:    spiffeLeaf,
+			wantErr: true,
+		},
+		{
+			name:       "unlisted SPIFFE ID is rejected",
+			authConfig: ServerAuthConfig{AcceptedSPIFFEIDs: map[string]struct{}{"spiffe://boulder/ca": {}}},
+			leaf:       spiffeLeaf,
+			wantErr:    true,
+		},
+		{
+			name:       "CN whitelist doesn't accept a matching SPIFFE ID on an unlisted CN",
+			authConfig: ServerAuthConfig{AcceptedCNs: map[string]struct{}{"ra.boulder": {}}},
+			leaf:       spiffeLeaf,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &serverTransportCredentials{authConfig: tt.authConfig}
+			state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.leaf}}}
+
+			info, err := tc.peerIsWhitelisted(state)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.wantMatched != "" && info.MatchedWhitelistEntry != tt.wantMatched {
+				t.Errorf("MatchedWhitelistEntry = %q, want %q", info.MatchedWhitelistEntry, tt.wantMatched)
+			}
+		})
+	}
+}
+
+// TestPeerIsWhitelistedRejectsZeroVerifiedChains proves that a configured
+// whitelist rejects a peer with no VerifiedChains at all, rather than
+// treating it as trivially authorized.
+func TestPeerIsWhitelistedRejectsZeroVerifiedChains(t *testing.T) {
+	tc := &serverTransportCredentials{
+		authConfig: ServerAuthConfig{AcceptedCNs: map[string]struct{}{"ra.boulder": {}}},
+	}
+	if _, err := tc.peerIsWhitelisted(tls.ConnectionState{}); err == nil {
+		t.Fatal("expected an error for zero VerifiedChains, got none")
+	}
+}
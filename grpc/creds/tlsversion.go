@@ -0,0 +1,44 @@
+package creds
+
+import "crypto/tls"
+
+// TLSVersionRange bounds the TLS protocol versions a transport will
+// negotiate with its peer.
+type TLSVersionRange struct {
+	MinVersion uint16
+	MaxVersion uint16
+}
+
+// defaultTLSVersionRange is used whenever a caller doesn't configure their
+// own TLSVersionRange. It allows TLS 1.3 to be negotiated opportunistically
+// while still supporting TLS 1.2 peers.
+var defaultTLSVersionRange = TLSVersionRange{
+	MinVersion: tls.VersionTLS12,
+	MaxVersion: tls.VersionTLS13,
+}
+
+// orDefault returns `r` unless it's the zero value, in which case it
+// returns defaultTLSVersionRange.
+func (r TLSVersionRange) orDefault() TLSVersionRange {
+	if r.MinVersion == 0 && r.MaxVersion == 0 {
+		return defaultTLSVersionRange
+	}
+	return r
+}
+
+// securityVersionString renders a tls.VersionTLS* constant the way
+// credentials.ProtocolInfo.SecurityVersion expects it.
+func securityVersionString(version uint16) string {
+	switch version {
+	case tls.VersionTLS13:
+		return "1.3"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS10:
+		return "1.0"
+	default:
+		return "1.2"
+	}
+}
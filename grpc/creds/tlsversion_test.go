@@ -0,0 +1,183 @@
+package creds
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// generateTestCert returns a self-signed certificate (and a pool trusting
+// it) suitable for exercising a TLS handshake in-process.
+func generateTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	return generateTestCertWithSANs(t, "boulder test cert", nil)
+}
+
+// generateTestCertWithSANs is like generateTestCert, but lets the caller set
+// the leaf's subject CN and URI SANs, so tests can exercise CN- and
+// SPIFFE-ID-based peer whitelisting.
+func generateTestCertWithSANs(t *testing.T, commonName string, uris []*url.URL) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{"localhost"},
+		URIs:                  uris,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating test cert: %s", err)
+	}
+	parsed, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatalf("parsing test cert: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{derBytes}, PrivateKey: priv}, pool
+}
+
+// TestServerHandshakeTLSVersionInterop proves that serverTransportCredentials,
+// configured with the default TLSVersionRange, can complete a handshake with
+// both a TLS 1.2-only peer and a TLS 1.3-only peer, and that Info()
+// afterwards reports whichever version was actually negotiated.
+func TestServerHandshakeTLSVersionInterop(t *testing.T) {
+	cert, pool := generateTestCert(t)
+	provider := NewStaticCertificateProvider(&KeyMaterial{
+		Certificates: []tls.Certificate{cert},
+		Roots:        pool,
+	})
+
+	for _, tt := range []struct {
+		name    string
+		version uint16
+	}{
+		{"TLS 1.2-only peer", tls.VersionTLS12},
+		{"TLS 1.3-only peer", tls.VersionTLS13},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+
+			server := NewServerTransportWithConfig(ServerAuthConfig{
+				ServerConfig: &tls.Config{ClientAuth: tls.NoClientCert},
+				Provider:     provider,
+			})
+
+			serverDone := make(chan error, 1)
+			go func() {
+				_, _, err := server.ServerHandshake(serverConn)
+				serverDone <- err
+			}()
+
+			client := tls.Client(clientConn, &tls.Config{
+				MinVersion:         tt.version,
+				MaxVersion:         tt.version,
+				InsecureSkipVerify: true,
+			})
+			if err := client.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %s", err)
+			}
+			defer client.Close()
+
+			if err := <-serverDone; err != nil {
+				t.Fatalf("server handshake failed: %s", err)
+			}
+
+			want := securityVersionString(tt.version)
+			if got := server.Info().SecurityVersion; got != want {
+				t.Errorf("server.Info().SecurityVersion = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestServerHandshakeCipherSuiteAllowlist proves that a configured
+// CipherSuites allowlist is actually honored during the handshake: a peer
+// offering only the allowed suite negotiates it, while a peer offering only
+// an excluded suite fails to negotiate at all.
+func TestServerHandshakeCipherSuiteAllowlist(t *testing.T) {
+	const (
+		allowedSuite  = tls.TLS_RSA_WITH_AES_128_GCM_SHA256
+		excludedSuite = tls.TLS_RSA_WITH_AES_256_GCM_SHA384
+	)
+
+	cert, pool := generateTestCert(t)
+	provider := NewStaticCertificateProvider(&KeyMaterial{
+		Certificates: []tls.Certificate{cert},
+		Roots:        pool,
+	})
+	server := NewServerTransportWithConfig(ServerAuthConfig{
+		ServerConfig:    &tls.Config{ClientAuth: tls.NoClientCert},
+		Provider:        provider,
+		TLSVersionRange: TLSVersionRange{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12},
+		CipherSuites:    []uint16{allowedSuite},
+	})
+
+	t.Run("peer offering the allowed suite negotiates it", func(t *testing.T) {
+		serverConn, clientConn := net.Pipe()
+		serverDone := make(chan error, 1)
+		go func() {
+			_, _, err := server.ServerHandshake(serverConn)
+			serverDone <- err
+		}()
+
+		client := tls.Client(clientConn, &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       []uint16{allowedSuite, excludedSuite},
+			InsecureSkipVerify: true,
+		})
+		if err := client.Handshake(); err != nil {
+			t.Fatalf("client handshake failed: %s", err)
+		}
+		defer client.Close()
+
+		if err := <-serverDone; err != nil {
+			t.Fatalf("server handshake failed: %s", err)
+		}
+		if got := client.ConnectionState().CipherSuite; got != allowedSuite {
+			t.Errorf("negotiated cipher suite = 0x%04x, want 0x%04x", got, allowedSuite)
+		}
+	})
+
+	t.Run("peer offering only an excluded suite is rejected", func(t *testing.T) {
+		serverConn, clientConn := net.Pipe()
+		serverDone := make(chan error, 1)
+		go func() {
+			_, _, err := server.ServerHandshake(serverConn)
+			serverDone <- err
+		}()
+
+		client := tls.Client(clientConn, &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       []uint16{excludedSuite},
+			InsecureSkipVerify: true,
+		})
+		if err := client.Handshake(); err == nil {
+			client.Close()
+			t.Fatal("expected client handshake to fail when it only offers an excluded cipher suite")
+		}
+
+		if err := <-serverDone; err == nil {
+			t.Fatal("expected ServerHandshake to fail when the peer only offers an excluded cipher suite")
+		}
+	})
+}